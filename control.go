@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultControlSocketPath is used when the config doesn't set one.
+const defaultControlSocketPath = "/run/sunrise/sunrise.ctl"
+
+// controlSocketPath returns the configured control socket path, falling
+// back to defaultControlSocketPath when unset.
+func controlSocketPath() string {
+	if cfg := currentConfig(); cfg.ControlSocketPath != "" {
+		return cfg.ControlSocketPath
+	}
+	return defaultControlSocketPath
+}
+
+// controlDispatcher executes control-socket verbs against the running
+// daemon's state. Each handler returns the text to send back to the caller.
+type controlDispatcher struct {
+	ctx        context.Context
+	state      *runtimeState
+	supervisor *RestartSupervisor
+}
+
+func newControlDispatcher(ctx context.Context, state *runtimeState, supervisor *RestartSupervisor) *controlDispatcher {
+	return &controlDispatcher{ctx: ctx, state: state, supervisor: supervisor}
+}
+
+// dispatch runs verb with args and returns the response line(s) to send to
+// the client, or an error if the verb is unknown or the action failed.
+func (d *controlDispatcher) dispatch(verb string, args []string) (string, error) {
+	switch verb {
+	case "status":
+		return d.status(), nil
+	case "wake":
+		return d.wake()
+	case "restart-sunshine":
+		return d.restartSunshine()
+	case "pause":
+		d.state.setPaused(true)
+		return "paused", nil
+	case "resume":
+		d.state.setPaused(false)
+		d.supervisor.ClearFatal()
+		return "resumed", nil
+	case "reload-config":
+		return d.reloadConfig()
+	case "tail":
+		return d.tail(args)
+	case "reset-tracking":
+		d.state.resetWakeTracking()
+		return "tracking reset", nil
+	default:
+		return "", fmt.Errorf("unknown verb %q", verb)
+	}
+}
+
+func (d *controlDispatcher) status() string {
+	snap := d.state.snapshot()
+	var b strings.Builder
+	fmt.Fprintf(&b, "paused: %v\n", snap.Paused)
+	fmt.Fprintf(&b, "in_wake_cycle: %v\n", snap.InWakeCycle)
+	fmt.Fprintf(&b, "last_wake_time: %s\n", formatOptionalTime(snap.LastWakeTime))
+	fmt.Fprintf(&b, "last_main_loop_time: %s\n", formatOptionalTime(snap.LastMainLoopTime))
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (d *controlDispatcher) wake() (string, error) {
+	Infof("Control socket: manual wake requested")
+	providers := buildWakeProviders(currentConfig())
+	if err := runWakeProviderChain(d.ctx, providers, wakeProviderTimeout(), wakeProviderBackoff()); err != nil {
+		return "", fmt.Errorf("wake failed: %w", err)
+	}
+	d.state.setLastWakeTime(time.Now())
+	return "woke monitor", nil
+}
+
+func (d *controlDispatcher) restartSunshine() (string, error) {
+	Infof("Control socket: manual restart requested")
+	if err := d.supervisor.Restart(d.ctx, d.state, "manual"); err != nil {
+		return "", fmt.Errorf("restart failed: %w", err)
+	}
+	return "sunshine restarted", nil
+}
+
+func (d *controlDispatcher) reloadConfig() (string, error) {
+	Infof("Control socket: config reload requested")
+	if err := reloadConfig(); err != nil {
+		return "", fmt.Errorf("reload failed: %w", err)
+	}
+	return "config reloaded", nil
+}
+
+// tail returns the last N cached log lines.
+func (d *controlDispatcher) tail(args []string) (string, error) {
+	n := 20
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid line count %q", args[0])
+		}
+		n = parsed
+	}
+
+	return strings.Join(CachedLogTail(n), "\n"), nil
+}
+
+// runControlServer listens on the control socket and serves verbs until ctx
+// is canceled or the listener fails.
+func runControlServer(ctx context.Context, state *runtimeState, socketPath string, supervisor *RestartSupervisor) error {
+	if err := os.RemoveAll(socketPath); err != nil {
+		return fmt.Errorf("could not clear stale control socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("could not listen on control socket: %w", err)
+	}
+	Infof("Control socket listening at %s", socketPath)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	dispatcher := newControlDispatcher(ctx, state, supervisor)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go handleControlConn(dispatcher, conn)
+	}
+}
+
+func handleControlConn(dispatcher *controlDispatcher, conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() {
+		return
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		fmt.Fprintln(conn, "error: empty command")
+		return
+	}
+
+	response, err := dispatcher.dispatch(fields[0], fields[1:])
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(conn, response)
+}
+
+// sendControlCommand connects to the control socket, sends verb+args, prints
+// the response to stdout, and returns the exit code the CLI should use.
+func sendControlCommand(socketPath string, verbAndArgs []string) int {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not connect to %s: %v\n", socketPath, err)
+		return 1
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, strings.Join(verbAndArgs, " "))
+
+	scanner := bufio.NewScanner(conn)
+	exitCode := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Println(line)
+		if strings.HasPrefix(line, "error:") {
+			exitCode = 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading response: %v\n", err)
+		return 1
+	}
+	return exitCode
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// runDebugServer serves /debug/log and /debug/state over plain HTTP, so a
+// headless box (Steam Deck, HTPC) can be triaged remotely without shelling
+// in to run journalctl. It's only started when the config sets DebugListen.
+func runDebugServer(ctx context.Context, addr string, state *runtimeState, tailer *logTailer) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/log", debugLogHandler)
+	mux.HandleFunc("/debug/state", debugStateHandler(state, tailer))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	Infof("Debug server listening at %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// debugLogHandler dumps recent cached log lines, newest last. ?n= caps how
+// many are returned (default 200).
+func debugLogHandler(w http.ResponseWriter, r *http.Request) {
+	n := 200
+	if v := r.URL.Query().Get("n"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			n = parsed
+		}
+	}
+	for _, line := range CachedLogTail(n) {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// debugStateHandler dumps the daemon's runtime state: wake/main-loop
+// tracking, the log tailer's byte offset, and the Sunshine PIDs it sees.
+func debugStateHandler(state *runtimeState, tailer *logTailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := state.snapshot()
+		fmt.Fprintf(w, "paused: %v\n", snap.Paused)
+		fmt.Fprintf(w, "in_wake_cycle: %v\n", snap.InWakeCycle)
+		fmt.Fprintf(w, "last_wake_time: %s\n", formatOptionalTime(snap.LastWakeTime))
+		fmt.Fprintf(w, "last_main_loop_time: %s\n", formatOptionalTime(snap.LastMainLoopTime))
+		fmt.Fprintf(w, "log_offset: %d\n", tailer.Offset())
+		fmt.Fprintf(w, "sunshine_pids: %v\n", getSunshinePIDs())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// verbosity gates Logf calls: a Logf(level, ...) call is printed only when
+// level is at or below verbosity. Infof/Errorf always print, regardless of
+// verbosity, since they're for routine and failure messages an operator
+// wants by default.
+var verbosity int
+
+func init() {
+	flag.IntVar(&verbosity, "v", 0, "log verbosity level for Logf calls")
+}
+
+// Logf prints a leveled debug message, gated by the -v flag.
+func Logf(level int, format string, args ...interface{}) {
+	if level > verbosity {
+		return
+	}
+	writeLog(format, args...)
+}
+
+// Infof prints a routine operational message.
+func Infof(format string, args ...interface{}) {
+	writeLog(format, args...)
+}
+
+// Errorf prints a failure worth surfacing without raising -v.
+func Errorf(format string, args ...interface{}) {
+	writeLog("ERROR: "+format, args...)
+}
+
+// Fatalf prints a failure and exits, mirroring the stdlib log.Fatal this
+// replaces.
+func Fatalf(format string, args ...interface{}) {
+	writeLog("FATAL: "+format, args...)
+	os.Exit(1)
+}
+
+func writeLog(format string, args ...interface{}) {
+	line := time.Now().Format("2006-01-02 15:04:05.000") + " " + fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, line)
+	logCache.add(line)
+}
+
+// ringLogCache tees recent log lines into memory so they can be retrieved
+// without re-reading stderr, e.g. via the control socket's tail verb or the
+// /debug/log endpoint. It's disabled (and a no-op) until EnableLogCaching is
+// called.
+type ringLogCache struct {
+	mu       sync.Mutex
+	enabled  bool
+	maxLines int
+	maxBytes int
+	bytes    int
+	lines    []string
+}
+
+var logCache ringLogCache
+
+// EnableLogCaching turns on the in-memory ring buffer, bounded by whichever
+// of maxLines/maxBytes is hit first; a zero value leaves that bound
+// unenforced.
+func EnableLogCaching(maxLines, maxBytes int) {
+	logCache.mu.Lock()
+	defer logCache.mu.Unlock()
+	logCache.enabled = true
+	logCache.maxLines = maxLines
+	logCache.maxBytes = maxBytes
+}
+
+func (c *ringLogCache) add(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.enabled {
+		return
+	}
+
+	c.lines = append(c.lines, line)
+	c.bytes += len(line)
+	for (c.maxLines > 0 && len(c.lines) > c.maxLines) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.bytes -= len(c.lines[0])
+		c.lines = c.lines[1:]
+	}
+}
+
+// CachedLogTail returns up to the last n cached log lines, oldest first. A
+// non-positive n returns everything cached.
+func CachedLogTail(n int) []string {
+	logCache.mu.Lock()
+	defer logCache.mu.Unlock()
+
+	if n <= 0 || n >= len(logCache.lines) {
+		out := make([]string, len(logCache.lines))
+		copy(out, logCache.lines)
+		return out
+	}
+	return append([]string(nil), logCache.lines[len(logCache.lines)-n:]...)
+}
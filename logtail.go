@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// maxLogLineBytes is the line length, complete or still unterminated, at
+// which readNewLines treats the log as the "corrupted log" case (mirrors the
+// old bufio.Scanner buffer cap).
+const maxLogLineBytes = 1024 * 1024
+
+// Bounds on how long handleEvent retries re-adding the fsnotify watch after
+// a rotation before giving up and falling back to polling.
+const (
+	maxRewatchAttempts = 10
+	maxRewatchBackoff  = 5 * time.Second
+)
+
+// logMatcher pairs a pattern against tailed log lines with a callback to run
+// whenever a line matches it.
+type logMatcher struct {
+	name    string
+	pattern *regexp.Regexp
+	onMatch func(line string)
+}
+
+// logTailer streams lines newly appended to a Sunshine log file to
+// registered matchers. It watches the file with fsnotify instead of
+// re-scanning the whole file on a timer, and falls back to stat-based
+// polling when fsnotify can't watch the path (e.g. a network filesystem).
+type logTailer struct {
+	path        string
+	offsetMu    sync.Mutex
+	offset      int64
+	matchers    []logMatcher
+	onCorrupted func() error
+}
+
+// newLogTailer creates a tailer for path. Register matchers and a
+// corruption handler before calling Run.
+func newLogTailer(path string) *logTailer {
+	return &logTailer{path: path}
+}
+
+// AddMatcher registers a regex pattern and callback invoked for every
+// tailed line that matches it.
+func (t *logTailer) AddMatcher(name, pattern string, onMatch func(line string)) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	t.matchers = append(t.matchers, logMatcher{name: name, pattern: re, onMatch: onMatch})
+	return nil
+}
+
+// SetCorruptionHandler registers the callback run when the log contains a
+// line too long to scan (the existing "corrupted log" case).
+func (t *logTailer) SetCorruptionHandler(fn func() error) {
+	t.onCorrupted = fn
+}
+
+// Offset returns the tailer's current byte offset into the log file, safe
+// to call from outside the goroutine running Run (e.g. the /debug/state
+// endpoint).
+func (t *logTailer) Offset() int64 {
+	t.offsetMu.Lock()
+	defer t.offsetMu.Unlock()
+	return t.offset
+}
+
+func (t *logTailer) setOffset(v int64) {
+	t.offsetMu.Lock()
+	defer t.offsetMu.Unlock()
+	t.offset = v
+}
+
+func (t *logTailer) addOffset(delta int64) {
+	t.offsetMu.Lock()
+	defer t.offsetMu.Unlock()
+	t.offset += delta
+}
+
+// Run tails the log file until ctx is canceled.
+func (t *logTailer) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		Infof("fsnotify unavailable (%v) - falling back to polling", err)
+		return t.runPolling(ctx)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(t.path); err != nil {
+		Errorf("Could not watch %s (%v) - falling back to polling", t.path, err)
+		return t.runPolling(ctx)
+	}
+
+	// Catch up on anything already in the file before waiting for events.
+	t.readNewLines()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !t.handleEvent(ctx, watcher, event) {
+				Errorf("Giving up on fsnotify after rotation - falling back to polling")
+				return t.runPolling(ctx)
+			}
+		case watchErr, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			Errorf("Log watcher error: %v", watchErr)
+		}
+	}
+}
+
+// handleEvent processes a single fsnotify event. It returns false if the
+// watch could not be re-established after a rotation, telling Run to fall
+// back to polling instead of tailing silently stalling forever.
+func (t *logTailer) handleEvent(ctx context.Context, watcher *fsnotify.Watcher, event fsnotify.Event) bool {
+	switch {
+	case event.Op&fsnotify.Write == fsnotify.Write:
+		t.readNewLines()
+	case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+		Infof("Sunshine log rotated - reopening")
+		t.setOffset(0)
+		return t.rewatchAfterRotation(ctx, watcher)
+	}
+	return true
+}
+
+// rewatchAfterRotation retries re-adding the fsnotify watch after Sunshine
+// (or logrotate) recreates the log file, backing off between attempts since
+// the new file may not land immediately. Returns false once
+// maxRewatchAttempts is exhausted with no success.
+func (t *logTailer) rewatchAfterRotation(ctx context.Context, watcher *fsnotify.Watcher) bool {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; attempt <= maxRewatchAttempts; attempt++ {
+		if sleepOrDone(ctx, backoff) {
+			return true // shutting down - not a fallback case
+		}
+		if err := watcher.Add(t.path); err == nil {
+			t.readNewLines()
+			return true
+		} else if attempt == maxRewatchAttempts {
+			Errorf("Could not re-watch %s after rotation, giving up after %d attempts: %v", t.path, attempt, err)
+		}
+		backoff *= 2
+		if backoff > maxRewatchBackoff {
+			backoff = maxRewatchBackoff
+		}
+	}
+	return false
+}
+
+// readNewLines reads everything appended to the log since the last known
+// offset, running it past registered matchers.
+func (t *logTailer) readNewLines() {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	offset := t.Offset()
+	if info.Size() < offset {
+		// Truncated in place (e.g. our own corrupted-log recovery) rather
+		// than rotated away.
+		offset = 0
+		t.setOffset(0)
+	}
+
+	if _, err := file.Seek(offset, os.SEEK_SET); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) >= maxLogLineBytes {
+			logCorruptionsTotal.Inc()
+			Errorf("Detected corrupted log with lines too long - clearing log and restarting sunshine")
+			if t.onCorrupted != nil {
+				if cerr := t.onCorrupted(); cerr != nil {
+					Errorf("Could not recover from corrupted log: %v", cerr)
+				}
+			}
+			t.setOffset(0)
+			return
+		}
+		if err != nil {
+			// No trailing newline yet (err is io.EOF) - a write in
+			// progress, or a buffered writer flushing mid-line. Leave
+			// these bytes at the current offset so the next write
+			// completes the line instead of us mis-reading it split.
+			return
+		}
+		t.addOffset(int64(len(line)))
+		t.publish(string(line[:len(line)-1]))
+	}
+}
+
+func (t *logTailer) publish(line string) {
+	for _, m := range t.matchers {
+		if m.pattern.MatchString(line) {
+			m.onMatch(line)
+		}
+	}
+}
+
+// runPolling is the fsnotify-less fallback: stat the file on an interval
+// and read whatever's new.
+func (t *logTailer) runPolling(ctx context.Context) error {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			t.readNewLines()
+		}
+	}
+}
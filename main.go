@@ -1,36 +1,28 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 var (
-	c config
-
-	// Track the log file size and last handled error time so we only react to
-	// new Sunshine errors.
-	lastLogSize            int64
-	lastMonitorMissingTime time.Time
-	lastEncoderFailureTime time.Time
-
-	// Wake-on-connect state tracking
-	lastMainLoopTime time.Time
-	lastWakeTime     time.Time
-	lastLogPosition  int64
-	inWakeCycle      bool
-	wakeCycleMutex  sync.Mutex
+	c          config
+	configMu   sync.RWMutex
+	configPath string
 )
 
 // config controls how sunrise functions
@@ -46,438 +38,535 @@ type config struct {
 	WakeMonitorCommand     string
 	EnableSunshineRestart  bool
 	RestartOnEncoderFailure bool
+	ControlSocketPath       string
+	ShutdownTimeoutSeconds  int
+	DebugListen             string
+	LogCacheLines           int
+	LogCacheBytes           int
+	WakeProviders              []string
+	DPMSOutput                 string
+	DDCUtilDisplay             string
+	CECDevice                  string
+	WakeOnLANMAC               string
+	WakeOnLANBroadcast         string
+	WakeOnLANPort              int
+	WakeProviderTimeoutSeconds int
+	WakeProviderBackoffSeconds int
+	MetricsListen              string
+	RestartBackoffBaseSeconds  int
+	RestartBackoffCapSeconds   int
+	RestartBackoffJitter       float64
+	RestartWindowSeconds       int
+	RestartWindowMaxRestarts   int
+}
+
+// currentConfig returns a copy of the current config, safe to read
+// concurrently with a SIGHUP-triggered reload.
+func currentConfig() config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return c
 }
 
 func main() {
-	configPath := flag.String("config", "/etc/sunrise/sunrise.cfg", "path to the sunrise config file")
+	flag.StringVar(&configPath, "config", "/etc/sunrise/sunrise.cfg", "path to the sunrise config file")
 	flag.Parse()
 
-	_, err := toml.DecodeFile(*configPath, &c)
-	if err != nil {
-		log.Fatal("Error reading toml config file:", err)
+	// probe-wake runs standalone against the config file - it doesn't need a
+	// running daemon, so it's checked before the control-socket dispatch.
+	if flag.NArg() > 0 && flag.Arg(0) == "probe-wake" {
+		os.Exit(runProbeWake())
 	}
 
-	log.Println("Starting sunrise monitoring service")
-	log.Printf("Monitor patterns: %s", c.MonitorIsOffLogLine)
-	log.Printf("Encoder patterns: %s | %s", c.EncoderFailedLogLine, c.EncoderFailedLogLine2)
-	log.Printf("Restart on encoder failure: %v", c.RestartOnEncoderFailure)
-
-	// Start the wake-on-connect state machine
-	go runWakeOnConnect()
+	// With no trailing arguments we're the daemon; `sunrise <verb> [args]`
+	// instead connects to a running daemon's control socket, prints its
+	// response, and exits.
+	if flag.NArg() > 0 {
+		os.Exit(sendControlCommand(controlSocketPath(), flag.Args()))
+	}
 
-	// Original periodic checking still runs for encoder failures
-	ticker := time.NewTicker(time.Duration(c.SunriseCheckSeconds) * time.Second)
-	for {
-		<-ticker.C
+	runDaemon()
+}
 
-		// Check for encoder failures (restart sunshine if enabled)
-		if c.RestartOnEncoderFailure {
-			encoderFailed, err := isEncoderFailed()
-			if err != nil {
-				log.Println("Error checking encoder failures:", err)
-				continue
-			}
-			if encoderFailed {
-				log.Println("Encoder failure detected - restarting sunshine")
-				err := restartSunshineSystemctlOnly()
-				if err != nil {
-					log.Println("Could not restart sunshine:", err)
-				}
-			}
+// runProbeWake loads the config and probes each configured wake provider in
+// turn, reporting which are usable on this machine without actually waking
+// anything - useful for picking a reliable WakeProviders order.
+func runProbeWake() int {
+	if err := reloadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not read config: %v\n", err)
+		return 1
+	}
+
+	providers := buildWakeProviders(currentConfig())
+	exitCode := 0
+	for _, p := range providers {
+		if err := p.Probe(); err != nil {
+			fmt.Printf("%-10s FAIL: %v\n", p.Name(), err)
+			exitCode = 1
+			continue
 		}
+		fmt.Printf("%-10s OK\n", p.Name())
 	}
+	return exitCode
 }
 
-// runWakeOnConnect implements the wake-on-connect state machine
-func runWakeOnConnect() {
-	cooldownPeriod := 2 * time.Minute
-	waitSeconds := 15
+// defaultShutdownTimeout bounds how long runDaemon waits for in-flight
+// wake/restart operations to finish when a shutdown signal arrives.
+const defaultShutdownTimeout = 30 * time.Second
 
-	for {
-		// Check if Sunshine is running
-		if !detectSunshineRunning() {
-			log.Println("Sunshine not running - restarting...")
-			restartSunshineSystemctlOnly()
-			time.Sleep(10 * time.Second)
-			continue
-		}
+// Defaults for the in-memory log ring buffer, used when the config leaves
+// LogCacheLines/LogCacheBytes unset.
+const (
+	defaultLogCacheLines = 500
+	defaultLogCacheBytes = 1 << 20 // 1 MiB
+)
 
-		// Check if we should wake (not in cooldown, Sunshine not already ready)
-		if shouldWakeMonitor(cooldownPeriod) {
-			log.Println("Waking monitor for connection...")
+// runDaemon loads the config and runs the sunrise monitoring service until
+// SIGTERM/SIGINT requests a graceful shutdown.
+func runDaemon() {
+	if err := reloadConfig(); err != nil {
+		Fatalf("Error reading toml config file: %v", err)
+	}
 
-			// Set wake cycle flag
-			wakeCycleMutex.Lock()
-			inWakeCycle = true
-			wakeCycleMutex.Unlock()
+	Infof("Starting sunrise monitoring service")
+	cfg := currentConfig()
+	Infof("Monitor patterns: %s", cfg.MonitorIsOffLogLine)
+	Infof("Encoder patterns: %s | %s", cfg.EncoderFailedLogLine, cfg.EncoderFailedLogLine2)
+	Infof("Restart on encoder failure: %v", cfg.RestartOnEncoderFailure)
 
-			// Wake monitor
-			err := wakeMonitor()
-			if err != nil {
-				log.Printf("Primary wake failed: %v", err)
-				// Try alternative wake method
-				err = alternativeWakeMethod()
-				if err != nil {
-					log.Printf("Alternative wake also failed: %v", err)
-				}
-			}
-
-			lastWakeTime = time.Now()
+	cacheLines, cacheBytes := defaultLogCacheLines, defaultLogCacheBytes
+	if cfg.LogCacheLines > 0 {
+		cacheLines = cfg.LogCacheLines
+	}
+	if cfg.LogCacheBytes > 0 {
+		cacheBytes = cfg.LogCacheBytes
+	}
+	EnableLogCaching(cacheLines, cacheBytes)
 
-			// Wait for initialization
-			log.Printf("Waiting %d seconds for monitor and Sunshine...", waitSeconds)
-			time.Sleep(time.Duration(waitSeconds) * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	state := newRuntimeState()
+	supervisor := newRestartSupervisor(cfg)
 
-			// Check for success
-			if checkForMainLoop() {
-				log.Println("Sunshine is ready - connection successful!")
-			} else {
-				log.Println("Sunshine not ready - restarting...")
-				restartSunshineSystemctlOnly()
-			}
+	subsystems := newSubsystemGroup()
 
-			// Clear wake cycle flag
-			wakeCycleMutex.Lock()
-			inWakeCycle = false
-			wakeCycleMutex.Unlock()
+	subsystems.spawn("control", func() {
+		if err := runControlServer(ctx, state, controlSocketPath(), supervisor); err != nil && ctx.Err() == nil {
+			Errorf("Control socket server stopped: %v", err)
 		}
+	})
 
-		// Check for Sunshine crash during wake cycle
-		wakeCycleMutex.Lock()
-		crashed := inWakeCycle && !detectSunshineRunning()
-		wakeCycleMutex.Unlock()
+	subsystems.spawn("wake-on-connect", func() {
+		runWakeOnConnect(ctx, state, supervisor)
+	})
 
-		if crashed {
-			log.Println("Sunshine crashed during wake cycle - restarting...")
-			restartSunshineSystemctlOnly()
-		}
+	tailer := newLogTailer(cfg.SunshineLogPath)
+	registerLogMatchers(tailer, ctx, state, supervisor)
 
-		// Check for new log activity periodically
-		hasNewActivity := checkForLogActivity()
-		if hasNewActivity {
-			// Small delay to let logs accumulate
-			time.Sleep(500 * time.Millisecond)
-		} else {
-		// No activity - sleep before next cycle
-			time.Sleep(time.Duration(c.SunriseCheckSeconds) * time.Second)
+	subsystems.spawn("log-tailer", func() {
+		if err := tailer.Run(ctx); err != nil && ctx.Err() == nil {
+			Errorf("Log tailer stopped: %v", err)
 		}
-	}
-}
+	})
 
-// shouldWakeMonitor checks if we should wake the monitor
-func shouldWakeMonitor(cooldownPeriod time.Duration) bool {
-	// Don't wake if Sunshine already ready (recent main loop)
-	if time.Since(lastMainLoopTime) < cooldownPeriod {
-		log.Println("Sunshine recently ready - skipping wake")
-		return false
+	if cfg.DebugListen != "" {
+		subsystems.spawn("debug-server", func() {
+			if err := runDebugServer(ctx, cfg.DebugListen, state, tailer); err != nil && ctx.Err() == nil {
+				Errorf("Debug server stopped: %v", err)
+			}
+		})
 	}
 
-	// Don't wake if in cooldown
-	if time.Since(lastWakeTime) < cooldownPeriod {
-		log.Println("In cooldown period - skipping wake")
-		return false
+	if cfg.MetricsListen != "" {
+		subsystems.spawn("metrics-server", func() {
+			if err := runMetricsServer(ctx, cfg.MetricsListen); err != nil && ctx.Err() == nil {
+				Errorf("Metrics server stopped: %v", err)
+			}
+		})
 	}
 
-	return true
+	waitForShutdownSignal(ctx, cancel, subsystems)
 }
 
-// checkForLogActivity checks if Sunshine has new log activity
-func checkForLogActivity() bool {
-	info, err := os.Stat(c.SunshineLogPath)
-	if err != nil {
-		return false
-	}
+// subsystemGroup tracks the daemon's long-running goroutines by name, so a
+// shutdown timeout can report which ones are still draining instead of a
+// generic "something's still running" message.
+type subsystemGroup struct {
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	live map[string]bool
+}
 
-	if info.Size() > lastLogPosition {
-		lastLogPosition = info.Size()
-		return true
-	}
+func newSubsystemGroup() *subsystemGroup {
+	return &subsystemGroup{live: make(map[string]bool)}
+}
 
-	return false
+// spawn starts fn in its own goroutine under name, tracked until fn returns.
+func (g *subsystemGroup) spawn(name string, fn func()) {
+	g.mu.Lock()
+	g.live[name] = true
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			g.mu.Lock()
+			delete(g.live, name)
+			g.mu.Unlock()
+		}()
+		fn()
+	}()
 }
 
-// checkForMainLoop checks if "Starting main loop" appears in recent logs
-func checkForMainLoop() bool {
-	file, err := os.Open(c.SunshineLogPath)
-	if err != nil {
-		log.Printf("Error opening log file: %v", err)
-		return false
+// remaining returns the names of subsystems that haven't returned yet,
+// sorted for stable log output.
+func (g *subsystemGroup) remaining() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	names := make([]string, 0, len(g.live))
+	for name := range g.live {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registerLogMatchers wires the log tailer's built-in detectors: Sunshine
+// reaching its main loop, an encoder failure, and the monitor going to
+// sleep. Each reacts to new log lines as they're written instead of
+// re-scanning the file on a timer.
+func registerLogMatchers(tailer *logTailer, ctx context.Context, state *runtimeState, supervisor *RestartSupervisor) {
+	cfg := currentConfig()
+
+	if err := tailer.AddMatcher("main-loop", regexp.QuoteMeta("Starting main loop"), func(line string) {
+		entryTime, err := parseSunshineTimestamp(line)
+		if err != nil {
+			entryTime = time.Now()
+		}
+		state.setLastMainLoopTime(entryTime)
+		lastMainLoopTimestampGauge.Set(float64(entryTime.Unix()))
+		if wokeAt := state.getLastWakeTime(); !wokeAt.IsZero() && entryTime.After(wokeAt) {
+			wakeToMainLoopSeconds.Observe(entryTime.Sub(wokeAt).Seconds())
+		}
+		Infof("Sunshine main loop detected at %s", entryTime.Format(time.RFC3339Nano))
+	}); err != nil {
+		Errorf("Could not register main-loop matcher: %v", err)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Buffer(make([]byte, 1024), 1024*1024)
+	if cfg.MonitorIsOffLogLine != "" {
+		if err := tailer.AddMatcher("monitor-off", regexp.QuoteMeta(cfg.MonitorIsOffLogLine), func(line string) {
+			monitorSleepEventsTotal.Inc()
+			Infof("Monitor sleep detected in log")
+		}); err != nil {
+			Errorf("Could not register monitor-off matcher: %v", err)
+		}
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "Starting main loop") {
-			// Update timestamp if found
-			entryTime, err := parseSunshineTimestamp(line)
-			if err == nil {
-				lastMainLoopTime = entryTime
-			} else {
-				lastMainLoopTime = time.Now()
+	if pattern := encoderFailurePattern(cfg); pattern != "" {
+		if err := tailer.AddMatcher("encoder-failed", pattern, func(line string) {
+			encoderFailuresTotal.Inc()
+			if !currentConfig().RestartOnEncoderFailure {
+				return
 			}
-			return true
+			Errorf("Encoder failure detected - restarting sunshine")
+			if err := supervisor.Restart(ctx, state, "encoder_failure"); err != nil {
+				Errorf("Could not restart sunshine: %v", err)
+			}
+		}); err != nil {
+			Errorf("Could not register encoder-failed matcher: %v", err)
 		}
 	}
 
-	return false
+	tailer.SetCorruptionHandler(func() error {
+		return handleCorruptedLog(ctx, state, supervisor)
+	})
 }
 
-// detectSunshineRunning checks if Sunshine service is running
-func detectSunshineRunning() bool {
-	cmd := exec.Command("systemctl", "--user", "is-active", "sunshine")
-	if err := cmd.Run(); err == nil {
-		return true
+// encoderFailurePattern builds an alternation regex matching either
+// configured encoder-failure log line, skipping any that are unset.
+func encoderFailurePattern(cfg config) string {
+	var alternatives []string
+	for _, line := range []string{cfg.EncoderFailedLogLine, cfg.EncoderFailedLogLine2} {
+		if line != "" {
+			alternatives = append(alternatives, regexp.QuoteMeta(line))
+		}
 	}
-	return false
+	if len(alternatives) == 0 {
+		return ""
+	}
+	pattern := alternatives[0]
+	for _, alt := range alternatives[1:] {
+		pattern += "|" + alt
+	}
+	return pattern
 }
 
-// wakeMonitor wakes the monitor using configured command
-func wakeMonitor() error {
-	parts := strings.Fields(c.WakeMonitorCommand)
-	if len(parts) == 0 {
-		return fmt.Errorf("no wake command configured")
+// waitForShutdownSignal blocks handling SIGHUP (config reload) until a
+// SIGTERM/SIGINT arrives, at which point it cancels ctx and waits for the
+// daemon's subsystems to drain before returning.
+func waitForShutdownSignal(ctx context.Context, cancel context.CancelFunc, subsystems *subsystemGroup) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP:
+			Infof("Received SIGHUP - reloading config")
+			if err := reloadConfig(); err != nil {
+				Errorf("Config reload failed: %v", err)
+				continue
+			}
+			Infof("Config reloaded")
+		default:
+			Infof("Received %v - shutting down gracefully", sig)
+			cancel()
+			drainSubsystems(subsystems, shutdownTimeout())
+			return
+		}
 	}
+}
 
-	cmd := exec.Command(parts[0], parts[1:]...)
-	log.Printf("Running wake command: %s", c.WakeMonitorCommand)
+// drainSubsystems waits up to timeout for all daemon goroutines to finish
+// in-flight wake/restart work, naming whichever subsystems are still
+// draining if the timeout is hit first.
+func drainSubsystems(subsystems *subsystemGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		subsystems.wg.Wait()
+		close(done)
+	}()
 
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("Wake command failed: %v", err)
-		return err
+	select {
+	case <-done:
+		Infof("All subsystems drained - exiting")
+	case <-time.After(timeout):
+		Errorf("Shutdown timeout exceeded - still draining: %s", strings.Join(subsystems.remaining(), ", "))
 	}
-
-	log.Println("Wake command completed")
-	return nil
 }
 
-// alternativeWakeMethod tries alternative ways to wake the monitor
-func alternativeWakeMethod() error {
-	log.Println("Trying alternative wake methods...")
-
-	// Try different ydotool approaches
-	methods := [][]string{
-		{"ydotool", "mousemove", "--absolute", "100", "100"},
-		{"ydotool", "key", "65"}, // Press 'A' key
+func shutdownTimeout() time.Duration {
+	if seconds := currentConfig().ShutdownTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
+	return defaultShutdownTimeout
+}
 
-	for _, args := range methods {
-		cmd := exec.Command(args[0], args[1:]...)
-		err := cmd.Run()
-		if err == nil {
-			log.Printf("Alternative wake succeeded: %v", args)
-			return nil
-		}
+func wakeProviderTimeout() time.Duration {
+	if seconds := currentConfig().WakeProviderTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
-
-	return fmt.Errorf("all alternative wake methods failed")
+	return defaultWakeProviderTimeout
 }
 
-// restartSunshineSystemctlOnly restarts Sunshine using systemctl only (no fallback)
-func restartSunshineSystemctlOnly() error {
-	log.Println("=== Restarting Sunshine via systemctl ===")
-
-	cmd := exec.Command("systemctl", "--user", "restart", "sunshine")
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("systemctl restart failed: %v", err)
-		log.Println("Waiting for next cycle...")
-		return err
+func wakeProviderBackoff() time.Duration {
+	if seconds := currentConfig().WakeProviderBackoffSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
+	return defaultWakeProviderBackoff
+}
 
-	log.Println("systemctl restart completed")
-
-	// Wait for service to be active
-	if err := waitForServiceActive("sunshine", 30); err != nil {
-		log.Printf("Sunshine service did not become active: %v", err)
+// reloadConfig (re-)reads the TOML config file at configPath and atomically
+// swaps it in for readers using currentConfig.
+func reloadConfig() error {
+	var newConfig config
+	if _, err := toml.DecodeFile(configPath, &newConfig); err != nil {
 		return err
 	}
 
-	// Reset tracking on log rotation
-	lastLogPosition = 0
-	lastMainLoopTime = time.Time{}
-
+	configMu.Lock()
+	c = newConfig
+	configMu.Unlock()
 	return nil
 }
 
-// waitForServiceActive waits for a systemd service to become active
-func waitForServiceActive(serviceName string, timeoutSeconds int) error {
-	log.Printf("Waiting up to %d seconds for %s...", timeoutSeconds, serviceName)
+// runWakeOnConnect implements the wake-on-connect state machine
+func runWakeOnConnect(ctx context.Context, state *runtimeState, supervisor *RestartSupervisor) {
+	cooldownPeriod := 2 * time.Minute
+	waitSeconds := 15
 
-	for i := 0; i < timeoutSeconds; i++ {
-		cmd := exec.Command("systemctl", "--user", "is-active", serviceName)
-		if err := cmd.Run(); err == nil {
-			log.Printf("Service %s is active", serviceName)
-			return nil
+	for {
+		if ctx.Err() != nil {
+			return
 		}
-		time.Sleep(1 * time.Second)
-	}
 
-	return fmt.Errorf("timeout waiting for %s", serviceName)
-}
+		if state.isPaused() {
+			if sleepOrDone(ctx, time.Duration(currentConfig().SunriseCheckSeconds)*time.Second) {
+				return
+			}
+			continue
+		}
 
-// isMonitorSleeping checks for monitor sleep errors
-func isMonitorSleeping() (isSleeping bool, err error) {
-	log.Println("Checking if monitor is sleeping")
-	logInfo, err := os.Stat(c.SunshineLogPath)
-	if err != nil {
-		return false, err
-	}
+		sunshineProcessCountGauge.Set(float64(countSunshineProcesses()))
 
-	if logInfo.Size() < lastLogSize {
-		log.Println("Sunshine log appears to have rotated; resetting tracking state")
-		resetMonitorTracking()
-	}
+		// Check if Sunshine is running
+		if !detectSunshineRunning(ctx) {
+			Errorf("Sunshine not running - restarting...")
+			supervisor.Restart(ctx, state, "not_running")
+			if sleepOrDone(ctx, 10*time.Second) {
+				return
+			}
+			continue
+		}
 
-	lastLogSize = logInfo.Size()
+		// Check if we should wake (not in cooldown, Sunshine not already ready)
+		if shouldWakeMonitor(state, cooldownPeriod) {
+			Infof("Waking monitor for connection...")
 
-	logFile, err := os.Open(c.SunshineLogPath)
-	if err != nil {
-		return false, err
-	}
-	defer logFile.Close()
+			// Set wake cycle flag
+			state.setInWakeCycle(true)
+			inWakeCycleGauge.Set(1)
+			wokeAt := time.Now()
 
-	var latestOccurrence time.Time
+			// Wake monitor
+			providers := buildWakeProviders(currentConfig())
+			if err := runWakeProviderChain(ctx, providers, wakeProviderTimeout(), wakeProviderBackoff()); err != nil {
+				Errorf("All wake providers failed: %v", err)
+			}
 
-	scanner := bufio.NewScanner(logFile)
-	scanner.Buffer(make([]byte, 1024), 1024*1024)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.Contains(line, c.MonitorIsOffLogLine) {
-			continue
-		}
+			state.setLastWakeTime(wokeAt)
+			lastWakeTimestampGauge.Set(float64(wokeAt.Unix()))
 
-		entryTime, err := parseSunshineTimestamp(line)
-		if err != nil {
-			log.Printf("Unable to parse timestamp: %v", err)
-			continue
-		}
+			// Wait for initialization; the log tailer updates
+			// lastMainLoopTime as soon as it sees Sunshine's main loop line.
+			Infof("Waiting %d seconds for monitor and Sunshine...", waitSeconds)
+			if sleepOrDone(ctx, time.Duration(waitSeconds)*time.Second) {
+				state.setInWakeCycle(false)
+				inWakeCycleGauge.Set(0)
+				return
+			}
 
-		if entryTime.After(latestOccurrence) {
-			latestOccurrence = entryTime
+			// Check for success. Sunshine crashing during the wake cycle
+			// still clears inWakeCycle below, so the process liveness check
+			// must happen here, while we still know this ready-check failed
+			// inside a wake cycle, not after.
+			if state.getLastMainLoopTime().After(wokeAt) {
+				Infof("Sunshine is ready - connection successful!")
+			} else if !detectSunshineRunning(ctx) {
+				Errorf("Sunshine crashed during wake cycle - restarting...")
+				supervisor.Restart(ctx, state, "crashed")
+			} else {
+				Errorf("Sunshine not ready - restarting...")
+				supervisor.Restart(ctx, state, "wake_failed")
+			}
+
+			// Clear wake cycle flag
+			state.setInWakeCycle(false)
+			inWakeCycleGauge.Set(0)
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		if isBufferOverflow(err) {
-			log.Println("Detected corrupted log with lines too long - clearing log and restarting sunshine")
-			return false, handleCorruptedLog()
+		if sleepOrDone(ctx, time.Duration(currentConfig().SunriseCheckSeconds)*time.Second) {
+			return
 		}
-		return false, err
 	}
+}
 
-	if latestOccurrence.IsZero() {
-		log.Println("Monitor is not sleeping")
-		return false, nil
+// sleepOrDone sleeps for d, returning early (true) if ctx is canceled first.
+func sleepOrDone(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return false
+	case <-ctx.Done():
+		return true
 	}
+}
 
-	if lastMonitorMissingTime.IsZero() || latestOccurrence.After(lastMonitorMissingTime) {
-		lastMonitorMissingTime = latestOccurrence
-		log.Println("Monitor sleep detected at", latestOccurrence.Format(time.RFC3339Nano))
-		return true, nil
+// shouldWakeMonitor checks if we should wake the monitor
+func shouldWakeMonitor(state *runtimeState, cooldownPeriod time.Duration) bool {
+	// Don't wake if Sunshine already ready (recent main loop)
+	if time.Since(state.getLastMainLoopTime()) < cooldownPeriod {
+		Logf(1, "Sunshine recently ready - skipping wake")
+		return false
 	}
 
-	log.Println("Monitor sleep already handled at", lastMonitorMissingTime.Format(time.RFC3339Nano))
-	return false, nil
-}
+	// Don't wake if in cooldown
+	if time.Since(state.getLastWakeTime()) < cooldownPeriod {
+		Logf(1, "In cooldown period - skipping wake")
+		return false
+	}
 
-// isBufferOverflow checks if scanner error is due to token too long
-func isBufferOverflow(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "token too long")
+	return true
 }
 
-// handleCorruptedLog clears the log and restarts sunshine
-func handleCorruptedLog() error {
-	log.Println("Truncating corrupted sunshine log")
-	if err := os.Truncate(c.SunshineLogPath, 0); err != nil {
-		log.Println("Failed to truncate log:", err)
-		return err
+// detectSunshineRunning checks if Sunshine service is running
+func detectSunshineRunning(ctx context.Context) bool {
+	cmd := exec.CommandContext(ctx, "systemctl", "--user", "is-active", "sunshine")
+	if err := cmd.Run(); err == nil {
+		return true
 	}
-	log.Println("Log truncated successfully, restarting sunshine")
-	return restartSunshineSystemctlOnly()
+	return false
 }
 
-// isEncoderFailed checks for encoder initialization failures
-func isEncoderFailed() (failed bool, err error) {
-	log.Println("Checking for encoder failures")
-	logInfo, err := os.Stat(c.SunshineLogPath)
+// restartSunshineSystemctlOnly restarts Sunshine using systemctl only (no
+// fallback). reason labels the sunrise_sunshine_restarts_total metric, e.g.
+// "not_running", "wake_failed", "crashed", "encoder_failure", "manual".
+func restartSunshineSystemctlOnly(ctx context.Context, state *runtimeState, reason string) error {
+	sunshineRestartsTotal.WithLabelValues(reason).Inc()
+	Infof("=== Restarting Sunshine via systemctl ===")
+
+	cmd := exec.CommandContext(ctx, "systemctl", "--user", "restart", "sunshine")
+	err := cmd.Run()
 	if err != nil {
-		return false, err
+		Errorf("systemctl restart failed: %v", err)
+		Infof("Waiting for next cycle...")
+		return err
 	}
 
-	lastLogSize = logInfo.Size()
+	Infof("systemctl restart completed")
 
-	logFile, err := os.Open(c.SunshineLogPath)
-	if err != nil {
-		return false, err
+	// Wait for service to be active
+	if err := waitForServiceActive(ctx, "sunshine", 30); err != nil {
+		Errorf("Sunshine service did not become active: %v", err)
+		return err
 	}
-	defer logFile.Close()
 
-	var latestOccurrence time.Time
+	// Reset tracking so the next wake cycle waits for a fresh main-loop line.
+	state.setLastMainLoopTime(time.Time{})
 
-	scanner := bufio.NewScanner(logFile)
-	scanner.Buffer(make([]byte, 1024), 1024*1024)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.Contains(line, c.EncoderFailedLogLine) &&
-			!strings.Contains(line, c.EncoderFailedLogLine2) {
-			continue
-		}
+	return nil
+}
 
-		entryTime, err := parseSunshineTimestamp(line)
-		if err != nil {
-			log.Printf("Unable to parse timestamp: %v", err)
-			continue
-		}
+// waitForServiceActive waits for a systemd service to become active
+func waitForServiceActive(ctx context.Context, serviceName string, timeoutSeconds int) error {
+	Infof("Waiting up to %d seconds for %s...", timeoutSeconds, serviceName)
 
-		if entryTime.After(latestOccurrence) {
-			latestOccurrence = entryTime
+	for i := 0; i < timeoutSeconds; i++ {
+		cmd := exec.CommandContext(ctx, "systemctl", "--user", "is-active", serviceName)
+		if err := cmd.Run(); err == nil {
+			Infof("Service %s is active", serviceName)
+			return nil
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		if isBufferOverflow(err) {
-			log.Println("Detected corrupted log with lines too long - clearing log and restarting sunshine")
-			return false, handleCorruptedLog()
+		if sleepOrDone(ctx, 1*time.Second) {
+			return ctx.Err()
 		}
-		return false, err
 	}
 
-	if latestOccurrence.IsZero() {
-		log.Println("No encoder failures detected")
-		return false, nil
-	}
+	return fmt.Errorf("timeout waiting for %s", serviceName)
+}
 
-	if lastEncoderFailureTime.IsZero() || latestOccurrence.After(lastEncoderFailureTime) {
-		lastEncoderFailureTime = latestOccurrence
-		log.Println("Encoder failure detected at", latestOccurrence.Format(time.RFC3339Nano))
-		return true, nil
+// handleCorruptedLog clears the log and restarts sunshine
+func handleCorruptedLog(ctx context.Context, state *runtimeState, supervisor *RestartSupervisor) error {
+	Errorf("Truncating corrupted sunshine log")
+	if err := os.Truncate(currentConfig().SunshineLogPath, 0); err != nil {
+		Errorf("Failed to truncate log: %v", err)
+		return err
 	}
-
-	log.Println("Encoder failure already handled at", lastEncoderFailureTime.Format(time.RFC3339Nano))
-	return false, nil
+	Infof("Log truncated successfully, restarting sunshine")
+	return supervisor.Restart(ctx, state, "log_corruption")
 }
 
 func wakeMonitorOld() (err error) {
-	wakeMonitorCommandAndArgs := strings.Split(c.WakeMonitorCommand, " ")
+	wakeMonitorCommandAndArgs := strings.Split(currentConfig().WakeMonitorCommand, " ")
 	wakeCMD := exec.Command(wakeMonitorCommandAndArgs[0], wakeMonitorCommandAndArgs[1:]...)
-	log.Println("Running wakeMonitor command:", wakeCMD.String())
+	Infof("Running wakeMonitor command: %s", wakeCMD.String())
 	err = wakeCMD.Run()
 	if err != nil {
 		return err
 	}
-	log.Println("wakeMonitor command completed without errors")
+	Infof("wakeMonitor command completed without errors")
 	return nil
 }
 
-func resetMonitorTracking() {
-	lastMonitorMissingTime = time.Time{}
-	lastEncoderFailureTime = time.Time{}
-}
-
 func parseSunshineTimestamp(line string) (time.Time, error) {
 	endIdx := strings.Index(line, "]")
 	if !strings.HasPrefix(line, "[") || endIdx == -1 {
@@ -494,24 +583,25 @@ func parseSunshineTimestamp(line string) (time.Time, error) {
 }
 
 func waitForMonitor() {
-	log.Println("Waiting", c.WakeMonitorSleepSeconds, "seconds for monitor to come up")
-	time.Sleep(time.Duration(c.WakeMonitorSleepSeconds) * time.Second)
+	sleepSeconds := currentConfig().WakeMonitorSleepSeconds
+	Infof("Waiting %d seconds for monitor to come up", sleepSeconds)
+	time.Sleep(time.Duration(sleepSeconds) * time.Second)
 }
 
 func stopSunshineProperly() error {
 	if systemdAvailable() {
-		log.Println("Stopping sunshine via systemd...")
+		Infof("Stopping sunshine via systemd...")
 		cmd := exec.Command("systemctl", "--user", "stop", "sunshine")
 		if err := cmd.Run(); err != nil {
-			log.Println("systemctl stop failed:", err)
+			Errorf("systemctl stop failed: %v", err)
 		} else {
-			log.Println("systemctl stop completed")
+			Infof("systemctl stop completed")
 			return nil
 		}
 	}
 
-	log.Println("Stopping sunshine via configured command...")
-	parts := strings.Fields(c.StopSunshineCommand)
+	Infof("Stopping sunshine via configured command...")
+	parts := strings.Fields(currentConfig().StopSunshineCommand)
 	if len(parts) == 0 {
 		return fmt.Errorf("no stop command configured")
 	}
@@ -521,18 +611,18 @@ func stopSunshineProperly() error {
 
 func startSunshineProperly() error {
 	if systemdAvailable() {
-		log.Println("Starting sunshine via systemd...")
+		Infof("Starting sunshine via systemd...")
 		cmd := exec.Command("systemctl", "--user", "start", "sunshine")
 		if err := cmd.Run(); err != nil {
-			log.Println("systemctl start failed:", err)
+			Errorf("systemctl start failed: %v", err)
 		} else {
-			log.Println("systemctl start completed")
+			Infof("systemctl start completed")
 			return nil
 		}
 	}
 
-	log.Println("Starting sunshine via configured command...")
-	parts := strings.Fields(c.StartSunshineCommand)
+	Infof("Starting sunshine via configured command...")
+	parts := strings.Fields(currentConfig().StartSunshineCommand)
 	if len(parts) == 0 {
 		return fmt.Errorf("no start command configured")
 	}
@@ -544,7 +634,7 @@ func startSunshineProperly() error {
 
 	go func() {
 		if err := cmd.Wait(); err != nil {
-			log.Printf("Sunshine process exited with error: %v", err)
+			Errorf("Sunshine process exited with error: %v", err)
 		}
 	}()
 
@@ -552,20 +642,20 @@ func startSunshineProperly() error {
 }
 
 func killAllSunshineProcesses() error {
-	log.Println("Killing all sunshine processes...")
+	Infof("Killing all sunshine processes...")
 
 	pids := getSunshinePIDs()
 	if len(pids) == 0 {
-		log.Println("No sunshine processes found")
+		Infof("No sunshine processes found")
 		return nil
 	}
 
-	log.Printf("Found %d sunshine process(es) to kill: %v", len(pids), pids)
+	Infof("Found %d sunshine process(es) to kill: %v", len(pids), pids)
 
 	for _, pid := range pids {
-		log.Printf("Sending SIGTERM to PID %d...", pid)
+		Infof("Sending SIGTERM to PID %d...", pid)
 		if err := killProcess(pid, 15); err != nil {
-			log.Printf("SIGTERM to PID %d failed: %v", pid, err)
+			Errorf("SIGTERM to PID %d failed: %v", pid, err)
 		}
 	}
 
@@ -573,10 +663,10 @@ func killAllSunshineProcesses() error {
 
 	remainingPids := getSunshinePIDs()
 	if len(remainingPids) > 0 {
-		log.Printf("Force killing %d remaining process(es): %v", len(remainingPids), remainingPids)
+		Infof("Force killing %d remaining process(es): %v", len(remainingPids), remainingPids)
 		for _, pid := range remainingPids {
 			if err := killProcess(pid, 9); err != nil {
-				log.Printf("SIGKILL to PID %d failed: %v", pid, err)
+				Errorf("SIGKILL to PID %d failed: %v", pid, err)
 			}
 		}
 	}
@@ -585,7 +675,7 @@ func killAllSunshineProcesses() error {
 }
 
 func forceKillAllSunshine() {
-	log.Println("Force killing all sunshine processes with SIGKILL...")
+	Infof("Force killing all sunshine processes with SIGKILL...")
 	cmd := exec.Command("killall", "-9", "sunshine")
 	cmd.Run()
 }
@@ -595,7 +685,7 @@ func getSunshinePIDs() []int {
 
 	entries, err := os.ReadDir("/proc")
 	if err != nil {
-		log.Println("Could not read /proc:", err)
+		Errorf("Could not read /proc: %v", err)
 		return pids
 	}
 
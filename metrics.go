@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics for the wake/restart state machine, exposed on MetricsListen for
+// Prometheus to scrape. Kept as package-level vars registered at import
+// time (promauto's usual pattern) since there's only ever one of each.
+var (
+	wakeAttemptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sunrise_wake_attempts_total",
+		Help: "Wake attempts per provider, labeled by result (success/failure).",
+	}, []string{"provider", "result"})
+
+	sunshineRestartsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sunrise_sunshine_restarts_total",
+		Help: "Sunshine restarts, labeled by the reason that triggered them.",
+	}, []string{"reason"})
+
+	encoderFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sunrise_encoder_failures_total",
+		Help: "Encoder failure log lines detected.",
+	})
+
+	monitorSleepEventsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sunrise_monitor_sleep_events_total",
+		Help: "Monitor-is-off log lines detected.",
+	})
+
+	logCorruptionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sunrise_log_corruptions_total",
+		Help: "Corrupted Sunshine log files detected, whether or not recovery succeeded.",
+	})
+
+	inWakeCycleGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sunrise_in_wake_cycle",
+		Help: "1 while a wake-on-connect cycle is in progress, 0 otherwise.",
+	})
+
+	lastMainLoopTimestampGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sunrise_last_main_loop_timestamp_seconds",
+		Help: "Unix timestamp of the last 'Starting main loop' line seen.",
+	})
+
+	lastWakeTimestampGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sunrise_last_wake_timestamp_seconds",
+		Help: "Unix timestamp of the last wake attempt.",
+	})
+
+	sunshineProcessCountGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sunrise_sunshine_process_count",
+		Help: "Number of running sunshine processes.",
+	})
+
+	wakeToMainLoopSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sunrise_wake_to_mainloop_seconds",
+		Help:    "Latency from issuing a wake to observing Sunshine's main loop line.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10), // 1s .. ~512s
+	})
+
+	restartSupervisorFatalGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sunrise_restart_supervisor_fatal",
+		Help: "1 while a restart reason has crash-looped and is latched Fatal, 0 otherwise.",
+	}, []string{"reason"})
+)
+
+// runMetricsServer serves /metrics until ctx is canceled.
+func runMetricsServer(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	Infof("Metrics server listening at %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
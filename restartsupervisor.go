@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Defaults for RestartSupervisor's backoff and crash-loop detection, used
+// when the config leaves the corresponding fields unset.
+const (
+	defaultRestartBackoffBase   = 5 * time.Second
+	defaultRestartBackoffCap    = 5 * time.Minute
+	defaultRestartBackoffJitter = 0.2 // +/-20%
+	defaultRestartWindow        = 2 * time.Minute
+	defaultRestartWindowMax     = 5
+)
+
+// errSupervisorFatal is wrapped into the error RestartSupervisor.Restart
+// returns once a reason has crash-looped past the configured threshold.
+var errSupervisorFatal = fmt.Errorf("restart supervisor: reason is fatal - run 'resume' via the control socket to clear it")
+
+// reasonBackoff tracks one restart reason's crash-loop window and backoff
+// state, so a genuine encoder-failure hiccup doesn't poison the backoff
+// used for, say, wake-on-connect restarts.
+type reasonBackoff struct {
+	attempts    []time.Time
+	lastAttempt time.Time
+	backoff     time.Duration
+	fatal       bool
+}
+
+// RestartSupervisor wraps restartSunshineSystemctlOnly with exponential
+// backoff and crash-loop detection, tracked independently per restart
+// reason ("not_running", "wake_failed", "crashed", "encoder_failure",
+// "log_corruption", "manual").
+type RestartSupervisor struct {
+	mu      sync.Mutex
+	reasons map[string]*reasonBackoff
+
+	backoffBase   time.Duration
+	backoffCap    time.Duration
+	backoffJitter float64
+	window        time.Duration
+	windowMax     int
+}
+
+// newRestartSupervisor builds a RestartSupervisor from cfg, falling back to
+// sane defaults for anything left unset.
+func newRestartSupervisor(cfg config) *RestartSupervisor {
+	s := &RestartSupervisor{
+		reasons:       make(map[string]*reasonBackoff),
+		backoffBase:   defaultRestartBackoffBase,
+		backoffCap:    defaultRestartBackoffCap,
+		backoffJitter: defaultRestartBackoffJitter,
+		window:        defaultRestartWindow,
+		windowMax:     defaultRestartWindowMax,
+	}
+	if cfg.RestartBackoffBaseSeconds > 0 {
+		s.backoffBase = time.Duration(cfg.RestartBackoffBaseSeconds) * time.Second
+	}
+	if cfg.RestartBackoffCapSeconds > 0 {
+		s.backoffCap = time.Duration(cfg.RestartBackoffCapSeconds) * time.Second
+	}
+	if cfg.RestartBackoffJitter > 0 {
+		s.backoffJitter = cfg.RestartBackoffJitter
+	}
+	if cfg.RestartWindowSeconds > 0 {
+		s.window = time.Duration(cfg.RestartWindowSeconds) * time.Second
+	}
+	if cfg.RestartWindowMaxRestarts > 0 {
+		s.windowMax = cfg.RestartWindowMaxRestarts
+	}
+	return s
+}
+
+func (s *RestartSupervisor) reasonState(reason string) *reasonBackoff {
+	rb, ok := s.reasons[reason]
+	if !ok {
+		rb = &reasonBackoff{}
+		s.reasons[reason] = rb
+	}
+	return rb
+}
+
+// Restart runs a supervised restart for reason: it waits out that reason's
+// current backoff, then calls restartSunshineSystemctlOnly. If the reason
+// has crash-looped past the configured threshold it refuses outright
+// (wrapping errSupervisorFatal) until ClearFatal is called, e.g. via the
+// control socket's resume verb.
+func (s *RestartSupervisor) Restart(ctx context.Context, state *runtimeState, reason string) error {
+	s.mu.Lock()
+	rb := s.reasonState(reason)
+
+	if rb.fatal {
+		s.mu.Unlock()
+		return fmt.Errorf("%w (reason %q)", errSupervisorFatal, reason)
+	}
+
+	now := time.Now()
+
+	// If Sunshine reached its main loop since the last restart attempt for
+	// this reason, it ran healthily in between - the crash streak is over,
+	// so let this reason's backoff and window start fresh.
+	if lastActive := state.getLastMainLoopTime(); !rb.lastAttempt.IsZero() && lastActive.After(rb.lastAttempt) {
+		rb.attempts = nil
+		rb.backoff = 0
+	}
+
+	rb.attempts = pruneBefore(rb.attempts, now.Add(-s.window))
+	rb.attempts = append(rb.attempts, now)
+	rb.lastAttempt = now
+
+	if len(rb.attempts) > s.windowMax {
+		rb.fatal = true
+		restartSupervisorFatalGauge.WithLabelValues(reason).Set(1)
+		s.mu.Unlock()
+		Errorf("=== Restart supervisor: %q crash-looped (%d restarts within %s) - marking FATAL, run 'resume' via the control socket to clear ===", reason, len(rb.attempts), s.window)
+		return fmt.Errorf("%w (reason %q)", errSupervisorFatal, reason)
+	}
+
+	wait := rb.backoff
+	if wait == 0 {
+		wait = s.backoffBase
+	}
+	rb.backoff = nextBackoff(wait, s.backoffCap)
+	s.mu.Unlock()
+
+	if wait > 0 {
+		Logf(1, "Restart supervisor: waiting %s before restarting (reason %q)", wait, reason)
+		if sleepOrDone(ctx, jitter(wait, s.backoffJitter)) {
+			return ctx.Err()
+		}
+	}
+
+	return restartSunshineSystemctlOnly(ctx, state, reason)
+}
+
+// ClearFatal resets every reason's crash-loop latch and backoff state, e.g.
+// when an operator runs 'resume' via the control socket after investigating
+// a crash loop.
+func (s *RestartSupervisor) ClearFatal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for reason, rb := range s.reasons {
+		if rb.fatal {
+			restartSupervisorFatalGauge.WithLabelValues(reason).Set(0)
+		}
+		rb.fatal = false
+		rb.attempts = nil
+		rb.backoff = 0
+	}
+}
+
+func pruneBefore(timestamps []time.Time, cutoff time.Time) []time.Time {
+	kept := timestamps[:0]
+	for _, t := range timestamps {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func nextBackoff(current, capDuration time.Duration) time.Duration {
+	next := current * 2
+	if next <= 0 || next > capDuration {
+		return capDuration
+	}
+	return next
+}
+
+// jitter returns d randomized by +/-frac (e.g. frac=0.2 means +/-20%).
+func jitter(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 {
+		return d
+	}
+	delta := float64(d) * frac
+	return d + time.Duration((rand.Float64()*2-1)*delta)
+}
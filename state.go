@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// runtimeState holds the daemon's mutable runtime state behind a single
+// mutex. It replaces the old lastWakeTime/inWakeCycle/lastMainLoopTime
+// package globals so the control socket can read and mutate them safely
+// from a goroutine other than the main wake loop.
+type runtimeState struct {
+	mu sync.Mutex
+
+	lastWakeTime     time.Time
+	lastMainLoopTime time.Time
+	inWakeCycle      bool
+	paused           bool
+}
+
+func newRuntimeState() *runtimeState {
+	return &runtimeState{}
+}
+
+func (s *runtimeState) setInWakeCycle(active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inWakeCycle = active
+}
+
+func (s *runtimeState) isInWakeCycle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inWakeCycle
+}
+
+func (s *runtimeState) setLastWakeTime(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWakeTime = t
+}
+
+func (s *runtimeState) getLastWakeTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastWakeTime
+}
+
+func (s *runtimeState) setLastMainLoopTime(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMainLoopTime = t
+}
+
+func (s *runtimeState) getLastMainLoopTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastMainLoopTime
+}
+
+func (s *runtimeState) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+func (s *runtimeState) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// resetWakeTracking clears the wake/main-loop timestamps so the next cycle
+// re-evaluates from a clean slate, e.g. via the control socket's
+// reset-tracking verb.
+func (s *runtimeState) resetWakeTracking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWakeTime = time.Time{}
+	s.lastMainLoopTime = time.Time{}
+}
+
+// snapshot is a point-in-time copy of the state used for reporting (e.g. the
+// control socket "status" verb) without holding the lock while formatting.
+type stateSnapshot struct {
+	LastWakeTime     time.Time
+	LastMainLoopTime time.Time
+	InWakeCycle      bool
+	Paused           bool
+}
+
+func (s *runtimeState) snapshot() stateSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return stateSnapshot{
+		LastWakeTime:     s.lastWakeTime,
+		LastMainLoopTime: s.lastMainLoopTime,
+		InWakeCycle:      s.inWakeCycle,
+		Paused:           s.paused,
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultWakeProviderTimeout bounds how long a single provider's Wake call
+// may run before the chain moves on to the next one.
+const defaultWakeProviderTimeout = 5 * time.Second
+
+// defaultWakeProviderBackoff is the initial delay between providers in the
+// chain; it doubles after each failed provider.
+const defaultWakeProviderBackoff = 2 * time.Second
+
+// WakeProvider is one way of waking the display attached to this machine.
+// Providers are tried in the configured order by runWakeProviderChain until
+// one succeeds.
+type WakeProvider interface {
+	// Name identifies the provider in logs and probe-wake output.
+	Name() string
+	// Wake attempts to wake the display, honoring ctx's deadline/cancellation.
+	Wake(ctx context.Context) error
+	// Probe reports whether the provider looks usable on this machine
+	// (binary present, config supplied) without actually waking anything.
+	Probe() error
+}
+
+// buildWakeProviders resolves cfg.WakeProviders into WakeProvider instances,
+// skipping names it doesn't recognize. With no providers configured it
+// falls back to the pre-chain behavior: the WakeMonitorCommand shim, then
+// ydotool.
+func buildWakeProviders(cfg config) []WakeProvider {
+	names := cfg.WakeProviders
+	if len(names) == 0 {
+		names = []string{"command", "ydotool"}
+	}
+
+	providers := make([]WakeProvider, 0, len(names))
+	for _, name := range names {
+		p := newWakeProvider(name, cfg)
+		if p == nil {
+			Errorf("Unknown wake provider %q - skipping", name)
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+func newWakeProvider(name string, cfg config) WakeProvider {
+	switch name {
+	case "command":
+		return &commandWakeProvider{command: cfg.WakeMonitorCommand}
+	case "ydotool":
+		return &ydotoolWakeProvider{}
+	case "dpms":
+		return &dpmsWakeProvider{output: cfg.DPMSOutput}
+	case "ddcutil":
+		return &ddcutilWakeProvider{display: cfg.DDCUtilDisplay}
+	case "cec":
+		return &cecWakeProvider{target: cfg.CECDevice}
+	case "wol":
+		return &wolWakeProvider{mac: cfg.WakeOnLANMAC, broadcast: cfg.WakeOnLANBroadcast, port: cfg.WakeOnLANPort}
+	default:
+		return nil
+	}
+}
+
+// runWakeProviderChain tries each provider in order, giving each up to
+// timeout to succeed. Between providers it waits backoff, doubling the wait
+// after every failure. Each provider is tried at most once per call, and the
+// wait starts again from backoff on every call, so the first provider runs
+// on every wake-on-connect cycle.
+func runWakeProviderChain(ctx context.Context, providers []WakeProvider, timeout, backoff time.Duration) error {
+	if len(providers) == 0 {
+		return fmt.Errorf("no wake providers configured")
+	}
+
+	var lastErr error
+	for i, p := range providers {
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		err := p.Wake(pctx)
+		cancel()
+
+		if err == nil {
+			wakeAttemptsTotal.WithLabelValues(p.Name(), "success").Inc()
+			Infof("Wake provider %q succeeded", p.Name())
+			return nil
+		}
+
+		wakeAttemptsTotal.WithLabelValues(p.Name(), "failure").Inc()
+		Errorf("Wake provider %q failed: %v", p.Name(), err)
+		lastErr = fmt.Errorf("%s: %w", p.Name(), err)
+
+		if i < len(providers)-1 {
+			if sleepOrDone(ctx, backoff) {
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("all wake providers failed: %w", lastErr)
+}
+
+// commandWakeProvider shells out to the operator-configured WakeMonitorCommand.
+// It's the pre-chain behavior, kept as the default first provider so
+// existing configs keep working unchanged.
+type commandWakeProvider struct {
+	command string
+}
+
+func (p *commandWakeProvider) Name() string { return "command" }
+
+func (p *commandWakeProvider) Wake(ctx context.Context) error {
+	parts := strings.Fields(p.command)
+	if len(parts) == 0 {
+		return fmt.Errorf("no wake command configured")
+	}
+	Infof("Running wake command: %s", p.command)
+	return exec.CommandContext(ctx, parts[0], parts[1:]...).Run()
+}
+
+func (p *commandWakeProvider) Probe() error {
+	parts := strings.Fields(p.command)
+	if len(parts) == 0 {
+		return fmt.Errorf("no wake command configured")
+	}
+	_, err := exec.LookPath(parts[0])
+	return err
+}
+
+// ydotoolWakeProvider nudges the mouse via ydotool, tapping a key instead
+// only if the mouse move fails - the original fallback wake method.
+type ydotoolWakeProvider struct{}
+
+func (p *ydotoolWakeProvider) Name() string { return "ydotool" }
+
+func (p *ydotoolWakeProvider) Wake(ctx context.Context) error {
+	methods := [][]string{
+		{"mousemove", "--absolute", "100", "100"},
+		{"key", "65"}, // Press 'A' key
+	}
+
+	var lastErr error
+	for _, args := range methods {
+		if err := exec.CommandContext(ctx, "ydotool", args...).Run(); err == nil {
+			return nil
+		} else {
+			lastErr = err
+		}
+	}
+	return fmt.Errorf("all ydotool methods failed: %w", lastErr)
+}
+
+func (p *ydotoolWakeProvider) Probe() error {
+	_, err := exec.LookPath("ydotool")
+	return err
+}
+
+// dpmsWakeProvider forces the display out of DPMS standby, via xset under
+// X11 or wlr-randr under wlroots-based Wayland compositors.
+type dpmsWakeProvider struct {
+	output string // wlr-randr output name, e.g. "HDMI-A-1"
+}
+
+func (p *dpmsWakeProvider) Name() string { return "dpms" }
+
+func (p *dpmsWakeProvider) Wake(ctx context.Context) error {
+	if err := exec.CommandContext(ctx, "xset", "dpms", "force", "on").Run(); err == nil {
+		return nil
+	}
+	if p.output == "" {
+		return fmt.Errorf("xset dpms failed and no DPMSOutput configured for the wlr-randr fallback")
+	}
+	return exec.CommandContext(ctx, "wlr-randr", "--output", p.output, "--on").Run()
+}
+
+func (p *dpmsWakeProvider) Probe() error {
+	if _, err := exec.LookPath("xset"); err == nil {
+		return nil
+	}
+	if p.output == "" {
+		return fmt.Errorf("no xset and no DPMSOutput configured for the wlr-randr fallback")
+	}
+	_, err := exec.LookPath("wlr-randr")
+	return err
+}
+
+// ddcutilWakeProvider powers the monitor on over DDC/CI (I2C), for displays
+// that don't wake from a DPMS signal alone.
+type ddcutilWakeProvider struct {
+	display string // ddcutil --display N; empty targets the default display
+}
+
+func (p *ddcutilWakeProvider) Name() string { return "ddcutil" }
+
+func (p *ddcutilWakeProvider) Wake(ctx context.Context) error {
+	args := []string{"setvcp", "D6", "01"}
+	if p.display != "" {
+		args = append([]string{"--display", p.display}, args...)
+	}
+	return exec.CommandContext(ctx, "ddcutil", args...).Run()
+}
+
+func (p *ddcutilWakeProvider) Probe() error {
+	_, err := exec.LookPath("ddcutil")
+	return err
+}
+
+// cecWakeProvider sends an HDMI-CEC "power on" to the attached display via
+// cec-client, for TV-as-monitor setups.
+type cecWakeProvider struct {
+	target string // CEC logical address to power on; empty defaults to "0" (TV)
+}
+
+func (p *cecWakeProvider) Name() string { return "cec" }
+
+func (p *cecWakeProvider) Wake(ctx context.Context) error {
+	target := p.target
+	if target == "" {
+		target = "0"
+	}
+	cmd := exec.CommandContext(ctx, "cec-client", "-s", "-d", "1")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("on %s\n", target))
+	return cmd.Run()
+}
+
+func (p *cecWakeProvider) Probe() error {
+	_, err := exec.LookPath("cec-client")
+	return err
+}
+
+// wolWakeProvider broadcasts a Wake-on-LAN magic packet, for networked
+// displays/capture devices that wake on one.
+type wolWakeProvider struct {
+	mac       string
+	broadcast string // defaults to 255.255.255.255
+	port      int    // defaults to 9
+}
+
+func (p *wolWakeProvider) Name() string { return "wol" }
+
+func (p *wolWakeProvider) Wake(ctx context.Context) error {
+	packet, err := wakeOnLANPacket(p.mac)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", fmt.Sprintf("%s:%d", p.broadcastAddr(), p.targetPort()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+func (p *wolWakeProvider) Probe() error {
+	_, err := wakeOnLANPacket(p.mac)
+	return err
+}
+
+func (p *wolWakeProvider) broadcastAddr() string {
+	if p.broadcast != "" {
+		return p.broadcast
+	}
+	return "255.255.255.255"
+}
+
+func (p *wolWakeProvider) targetPort() int {
+	if p.port != 0 {
+		return p.port
+	}
+	return 9
+}
+
+// wakeOnLANPacket builds a Wake-on-LAN magic packet: six 0xFF bytes followed
+// by the target MAC address repeated sixteen times.
+func wakeOnLANPacket(mac string) ([]byte, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WakeOnLANMAC %q: %w", mac, err)
+	}
+
+	packet := make([]byte, 0, 6+16*len(hwAddr))
+	for i := 0; i < 6; i++ {
+		packet = append(packet, 0xFF)
+	}
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hwAddr...)
+	}
+	return packet, nil
+}